@@ -1,6 +1,10 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -16,22 +20,47 @@ import (
 	tpipeline "github.com/hscells/transmute/pipeline"
 	"github.com/hscells/trecresults"
 	"github.com/ielab/searchrefiner"
+	"github.com/peterbourgon/diskv"
 	"log"
 	"net/http"
+	"os"
 	"sort"
+	"strconv"
+	"sync"
 	"time"
 )
 
 type QueryLensPlugin struct{}
 
 type lensRequest struct {
-	Query    string `json:"query"`
-	Language string `json:"language"`
+	Query      string   `json:"query"`
+	Language   string   `json:"language"`
+	Objectives []string `json:"objectives"`
+
+	// SortBy orders the resulting connection by one of "f1" (the default),
+	// "recall", "precision" or "numRet", highest first.
+	SortBy string `json:"sortBy"`
+	// MinRecall drops variations scoring below it, when greater than zero.
+	MinRecall float64 `json:"minRecall"`
+	// Transformation, when set, keeps only variations produced by the named
+	// transformer (see transformationType), or "Original"/an objective name
+	// for the original query and LTR-selected candidates respectively.
+	Transformation string `json:"transformation"`
+	// Shape, when set, keeps only variations plotted with that marker shape.
+	Shape string `json:"shape"`
+	// After is the cursor of the last edge the caller has already seen;
+	// the connection resumes immediately after it. Empty starts from the
+	// first page.
+	After string `json:"after"`
+	// First caps the number of edges returned. Defaults to
+	// defaultConnectionSize.
+	First int `json:"first"`
 }
 
 type queryVariation struct {
 	Query              string  `json:"query"`
 	Shape              string  `json:"shape"`
+	Objective          string  `json:"objective"`
 	Transformation     string  `json:"transformation"`
 	NumTransformations int     `json:"num_transformations"`
 	Precision          float64 `json:"precision"`
@@ -40,9 +69,30 @@ type queryVariation struct {
 	NumRet             float64 `json:"num_ret"`
 }
 
+// queryEdge pairs a variation with a cursor identifying its position in the
+// connection, so a caller can resume pagination after it via lensRequest.After.
+type queryEdge struct {
+	Cursor string         `json:"cursor"`
+	Node   queryVariation `json:"node"`
+}
+
+// pageInfo reports whether more edges are available beyond the returned
+// page, and the cursor to resume from.
+type pageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+// queryConnection is a Relay-style connection over a sorted, filtered set of
+// query variations, built by paginateVariations.
+type queryConnection struct {
+	Edges    []queryEdge `json:"edges"`
+	PageInfo pageInfo    `json:"pageInfo"`
+}
+
 type lensResponse struct {
 	Type     string           `json:"type"`
-	Queries  []queryVariation `json:"queries"`
+	Queries  *queryConnection `json:"queries"`
 	Progress float64          `json:"progress"`
 	Message  string           `json:"message"`
 }
@@ -65,6 +115,163 @@ var transformationType = []string{
 	"MeSH Parent",
 }
 
+// ltrModels registers the LTR model to use for a given objective/language
+// pair, mirroring the `models` registry kept by the sibling chain plugin.
+// "balanced" is retained as the default objective for backwards
+// compatibility with clients that don't yet send one.
+var ltrModels = map[string]map[string]string{
+	"balanced": {
+		"medline": "plugin/querylens/balanced.xml",
+		"pubmed":  "plugin/querylens/balanced.xml",
+	},
+	"precision": {
+		"medline": "plugin/querylens/medline_precision.xml",
+		"pubmed":  "plugin/querylens/pubmed_precision.xml",
+	},
+	"recall": {
+		"medline": "plugin/querylens/medline_recall.xml",
+		"pubmed":  "plugin/querylens/pubmed_recall.xml",
+	},
+	"f1": {
+		"medline": "plugin/querylens/medline_f1.xml",
+		"pubmed":  "plugin/querylens/pubmed_f1.xml",
+	},
+}
+
+// objectiveShapes assigns each objective a distinct marker shape so the UI
+// can plot several objectives' selected variations on the same chart.
+var objectiveShapes = map[string]string{
+	"balanced":  "triangle",
+	"precision": "square",
+	"recall":    "diamond",
+	"f1":        "star",
+}
+
+// objectives returns the sorted list of objectives with a registered model,
+// used both to drive selection and to populate the UI's dropdown.
+func objectives() []string {
+	names := make([]string, 0, len(ltrModels))
+	for name := range ltrModels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// variationWorkers bounds how many variations are evaluated against Entrez
+// concurrently. It defaults to a conservative value that stays within
+// Entrez's unauthenticated rate limit, and can be raised via the
+// QUERYLENS_WORKERS environment variable for servers using an API key.
+var variationWorkers = func() int {
+	if v := os.Getenv("QUERYLENS_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 3
+}()
+
+// evaluationCache persists retrieved document IDs and evaluation scores for
+// a (query, relevance set) pair to disk, modeled on the chain plugin's
+// diskv-backed statistics cache. This lets a researcher who repeatedly
+// refines one transformation at a time skip Entrez round-trips for
+// variations they've already evaluated in a prior session.
+var evaluationCache = diskv.New(diskv.Options{
+	BasePath:     "plugin/querylens/cache",
+	Transform:    func(key string) []string { return []string{key[:2], key[2:4]} },
+	CacheSizeMax: 64 * 1024 * 1024,
+})
+
+// evaluationResult is the cached outcome of retrieving and scoring a query.
+type evaluationResult struct {
+	DocIDs    []string `json:"doc_ids"`
+	Precision float64  `json:"precision"`
+	Recall    float64  `json:"recall"`
+	F1        float64  `json:"f1"`
+	NumRet    float64  `json:"num_ret"`
+}
+
+// evaluationCacheKey hashes the CQR representation together with the
+// relevance set so that the same query evaluated against a different set
+// of qrels doesn't share a cache entry.
+func evaluationCacheKey(query cqr.CommonQueryRepresentation, relevant trecresults.Qrels) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%+v", query)
+	docIDs := make([]string, 0, len(relevant))
+	for docID := range relevant {
+		docIDs = append(docIDs, docID)
+	}
+	sort.Strings(docIDs)
+	for _, docID := range docIDs {
+		h.Write([]byte(docID))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// queryCache adapts a combinator.MapQueryCache so only its own Get/Set
+// bookkeeping is serialized, not whatever the caller does with the result.
+// LogicalTree.Documents performs the Entrez round trip on a cache miss
+// itself — exactly the "dominant latency" chunk0-2 added variationWorkers
+// to overlap — so a lock held across the whole Documents() call would
+// serialize that fetch right back to the original for-loop's throughput.
+// MapQueryCache isn't documented as safe for concurrent reads/writes, and
+// variationWorkers goroutines now share one per request, so its Get/Set are
+// the only calls that need guarding.
+type queryCache struct {
+	mu sync.Mutex
+	*combinator.MapQueryCache
+}
+
+func (c *queryCache) Get(key string) (combinator.Documents, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.MapQueryCache.Get(key)
+}
+
+func (c *queryCache) Set(key string, docs combinator.Documents) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.MapQueryCache.Set(key, docs)
+}
+
+// evaluateQuery retrieves and scores query against s, consulting the disk
+// cache first. qc is the in-memory cache shared across every evaluation for
+// a single request. qrels scores the results, while settings.Relevant is
+// passed through to combinator.NewShallowLogicalTree unchanged.
+func evaluateQuery(qc *queryCache, s searchrefiner.Server, settings searchrefiner.Settings, qrels trecresults.Qrels, query cqr.CommonQueryRepresentation) (evaluationResult, error) {
+	key := evaluationCacheKey(query, qrels)
+	if data, err := evaluationCache.Read(key); err == nil {
+		var cached evaluationResult
+		if err := json.Unmarshal(data, &cached); err == nil {
+			return cached, nil
+		}
+	}
+
+	pq := pipeline.NewQuery("0", "0", query)
+	t, err := combinator.NewShallowLogicalTree(pq, s.Entrez, settings.Relevant)
+	if err != nil {
+		return evaluationResult{}, err
+	}
+	results := t.Documents(qc).Results(pq, "0")
+
+	docIDs := make([]string, len(results))
+	for i, r := range results {
+		docIDs[i] = r.DocId
+	}
+
+	result := evaluationResult{
+		DocIDs:    docIDs,
+		Precision: eval.Precision.Score(&results, qrels),
+		Recall:    eval.Recall.Score(&results, qrels),
+		F1:        eval.F1Measure.Score(&results, qrels),
+		NumRet:    float64(len(results)),
+	}
+	if data, err := json.Marshal(result); err == nil {
+		_ = evaluationCache.Write(key, data)
+	}
+	return result, nil
+}
+
 func generateVariations(query cqr.CommonQueryRepresentation, s stats.StatisticsSource, me analysis.MeasurementExecutor, transformations ...learning.Transformation) ([]learning.CandidateQuery, error) {
 	return learning.Variations(
 		learning.NewCandidateQuery(query, "querylens", nil),
@@ -74,15 +281,24 @@ func generateVariations(query cqr.CommonQueryRepresentation, s stats.StatisticsS
 		transformations...)
 }
 
-func wsEvent(ws *websocket.Conn, s searchrefiner.Server, settings searchrefiner.Settings) {
-
-	readWait := 1 * time.Millisecond
-	readTicker := time.NewTicker(readWait)
-
-	defer func() {
-		readTicker.Stop()
-		_ = ws.Close()
-	}()
+// progressFunc reports intermediate lensResponse messages while
+// runVariations is working. It is nil for callers, such as the JSON
+// endpoint, that only care about the final result.
+type progressFunc func(lensResponse) error
+
+// runVariations runs the full variation generation, LTR selection and
+// evaluation pipeline for request, shared by both the websocket handler and
+// the JSON endpoint. progress may be nil.
+func runVariations(ctx context.Context, request lensRequest, s searchrefiner.Server, settings searchrefiner.Settings, progress progressFunc) ([]queryVariation, error) {
+	report := func(resp lensResponse) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if progress == nil {
+			return nil
+		}
+		return progress(resp)
+	}
 
 	qrels := trecresults.NewQrelsFile()
 	qrels.Qrels["0"] = make(trecresults.Qrels, len(settings.Relevant))
@@ -91,211 +307,576 @@ func wsEvent(ws *websocket.Conn, s searchrefiner.Server, settings searchrefiner.
 	}
 	eval.RelevanceGrade = 0
 
-	qc := combinator.NewMapQueryCache()
+	qc := &queryCache{MapQueryCache: combinator.NewMapQueryCache()}
 
-	for {
-		select {
-		case <-readTicker.C:
-			var request lensRequest
-			err := ws.ReadJSON(&request)
-			if err != nil {
-				log.Println("read:", err)
-				return
-			}
-			rawQuery := request.Query
-			lang := request.Language
-
-			p := make(map[string]tpipeline.TransmutePipeline)
-			p["medline"] = transmute.Medline2Cqr
-			p["pubmed"] = transmute.Pubmed2Cqr
-
-			compiler := p["medline"]
-			if v, ok := p[lang]; ok {
-				compiler = v
-			} else {
-				lang = "medline"
-			}
+	rawQuery := request.Query
+	lang := request.Language
 
-			log.Printf("recieved a query %s in format %s\n", rawQuery, lang)
+	p := make(map[string]tpipeline.TransmutePipeline)
+	p["medline"] = transmute.Medline2Cqr
+	p["pubmed"] = transmute.Pubmed2Cqr
 
-			cq, err := compiler.Execute(rawQuery)
-			if err != nil {
-				log.Println("read:", err)
-				return
-			}
-			repr, err := cq.Representation()
-			if err != nil {
-				log.Println("read:", err)
-				return
-			}
+	compiler := p["medline"]
+	if v, ok := p[lang]; ok {
+		compiler = v
+	} else {
+		lang = "medline"
+	}
 
-			err = ws.WriteJSON(lensResponse{
-				Type:    "message",
-				Message: "Generating variations.",
-			})
-			if err != nil {
-				log.Println("write:", err)
-				return
-			}
+	log.Printf("recieved a query %s in format %s\n", rawQuery, lang)
 
-			candidate := learning.NewCandidateQuery(repr.(cqr.CommonQueryRepresentation), "0", nil)
-
-			variations, err := generateVariations(repr.(cqr.CommonQueryRepresentation), s.Entrez, analysis.NewMemoryMeasurementExecutor(),
-				learning.NewMeSHExplosionTransformer(),
-				learning.NewLogicalOperatorTransformer(),
-				learning.NewFieldRestrictionsTransformer(),
-				learning.NewMeshParentTransformer(),
-				learning.NewClauseRemovalTransformer(),
-				learning.Newcui2vecExpansionTransformer(s.CUIEmbeddings, s.CUIMapping, s.QuicheCache),
-			)
-
-			err = ws.WriteJSON(lensResponse{
-				Type:    "message",
-				Message: "Predicting most effective variation.",
-			})
-			if err != nil {
-				log.Println("write:", err)
-				return
-			}
+	cq, err := compiler.Execute(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	repr, err := cq.Representation()
+	if err != nil {
+		return nil, err
+	}
 
-			// selector is a quickrank candidate selector configured to only select to a depth of one.
-			ltrModel := learning.NewQuickRankQueryCandidateSelector(
-				s.Config.Resources.QuickRank,
-				map[string]interface{}{
-					"model-in":    "plugin/querylens/balanced.xml",
-					"test-metric": "DCG",
-					"test-cutoff": 1,
-					"scores":      uuid.New().String(),
-				},
-				learning.QuickRankCandidateSelectorMaxDepth(1),
-				learning.QuickRankCandidateSelectorStatisticsSource(s.Entrez),
-			)
-			ltrCandidate, _, err := ltrModel.Select(candidate, variations)
-			if err != nil {
-				log.Println("read:", err)
-				return
-			}
+	if err := report(lensResponse{Type: "message", Message: "Generating variations."}); err != nil {
+		return nil, err
+	}
 
-			err = ws.WriteJSON(lensResponse{
-				Type:    "message",
-				Message: "Evaluating queries.",
-			})
-			if err != nil {
-				log.Println("write:", err)
-				return
-			}
+	candidate := learning.NewCandidateQuery(repr.(cqr.CommonQueryRepresentation), "0", nil)
+
+	variations, err := generateVariations(repr.(cqr.CommonQueryRepresentation), s.Entrez, analysis.NewMemoryMeasurementExecutor(),
+		learning.NewMeSHExplosionTransformer(),
+		learning.NewLogicalOperatorTransformer(),
+		learning.NewFieldRestrictionsTransformer(),
+		learning.NewMeshParentTransformer(),
+		learning.NewClauseRemovalTransformer(),
+		learning.Newcui2vecExpansionTransformer(s.CUIEmbeddings, s.CUIMapping, s.QuicheCache),
+	)
+	if err != nil {
+		return nil, err
+	}
 
-			var backend func(cqr.CommonQueryRepresentation) (string, error)
+	if err := report(lensResponse{Type: "message", Message: "Predicting most effective variation."}); err != nil {
+		return nil, err
+	}
+
+	// A client that predates selectable objectives (or simply omits the
+	// field) gets the single "balanced" objective it always got, not one
+	// QuickRank select per registered objective — see the back-compat note
+	// on ltrModels above.
+	requestedObjectives := request.Objectives
+	if len(requestedObjectives) == 0 {
+		requestedObjectives = []string{"balanced"}
+	}
 
-			queries := make([]queryVariation, len(variations))
-			switch lang {
-			case "pubmed":
-				backend = transmute.CompileCqr2PubMed
-			default:
-				backend = transmute.CompileCqr2Medline
+	// selector is a quickrank candidate selector configured to only select to a depth of one.
+	ltrCandidates := make(map[string]learning.CandidateQuery, len(requestedObjectives))
+	for _, objective := range requestedObjectives {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		models, ok := ltrModels[objective]
+		if !ok {
+			continue
+		}
+		modelIn, ok := models[lang]
+		if !ok {
+			modelIn = models["medline"]
+		}
+		ltrModel := learning.NewQuickRankQueryCandidateSelector(
+			s.Config.Resources.QuickRank,
+			map[string]interface{}{
+				"model-in":    modelIn,
+				"test-metric": "DCG",
+				"test-cutoff": 1,
+				"scores":      uuid.New().String(),
+			},
+			learning.QuickRankCandidateSelectorMaxDepth(1),
+			learning.QuickRankCandidateSelectorStatisticsSource(s.Entrez),
+		)
+
+		// Select doesn't accept a context, so run it in the background and
+		// abandon it if ctx is cancelled before it finishes.
+		type selectResult struct {
+			candidate learning.CandidateQuery
+			err       error
+		}
+		selected := make(chan selectResult, 1)
+		go func() {
+			c, _, err := ltrModel.Select(candidate, variations)
+			selected <- selectResult{c, err}
+		}()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case res := <-selected:
+			if res.err != nil {
+				return nil, res.err
 			}
+			ltrCandidates[objective] = res.candidate
+		}
+	}
+
+	if err := report(lensResponse{Type: "message", Message: "Evaluating queries."}); err != nil {
+		return nil, err
+	}
+
+	var backend func(cqr.CommonQueryRepresentation) (string, error)
+
+	queries := make([]queryVariation, len(variations))
+	switch lang {
+	case "pubmed":
+		backend = transmute.CompileCqr2PubMed
+	default:
+		backend = transmute.CompileCqr2Medline
+	}
+
+	type variationResult struct {
+		index     int
+		variation queryVariation
+		err       error
+	}
+
+	jobs := make(chan int)
+	done := make(chan variationResult)
+
+	var workers sync.WaitGroup
+	for w := 0; w < variationWorkers; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range jobs {
+				if err := ctx.Err(); err != nil {
+					done <- variationResult{index: i, err: err}
+					continue
+				}
 
-			for i := range variations {
 				q, err := backend(variations[i].Query)
 				if err != nil {
-					log.Println("write:", err)
-					return
+					done <- variationResult{index: i, err: err}
+					continue
 				}
 
-				pq := pipeline.NewQuery("0", "0", variations[i].Query)
-				t, err := combinator.NewShallowLogicalTree(pq, s.Entrez, settings.Relevant)
+				ev, err := evaluateQuery(qc, s, settings, qrels.Qrels["0"], variations[i].Query)
 				if err != nil {
-					log.Println("write:", err)
-					return
+					done <- variationResult{index: i, err: err}
+					continue
 				}
-				results := t.Documents(qc).Results(pq, "0")
 
-				queries[i] = queryVariation{
+				done <- variationResult{index: i, variation: queryVariation{
 					Query:              q,
 					Shape:              "circle",
 					Transformation:     transformationType[variations[i].TransformationID],
 					NumTransformations: len(variations),
-					Precision:          eval.Precision.Score(&results, qrels.Qrels["0"]),
-					Recall:             eval.Recall.Score(&results, qrels.Qrels["0"]),
-					F1:                 eval.F1Measure.Score(&results, qrels.Qrels["0"]),
-					NumRet:             float64(len(results)),
-				}
+					Precision:          ev.Precision,
+					Recall:             ev.Recall,
+					F1:                 ev.F1,
+					NumRet:             ev.NumRet,
+				}}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := range variations {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
 
-				fmt.Println(queries[i].Precision, queries[i].Recall, queries[i].F1, eval.NumRel.Score(&results, qrels.Qrels["0"]))
+	// Drain every result before returning on error so the worker
+	// goroutines above never block on a send to an abandoned channel.
+	var evalErr error
+	completed := 0
+	for res := range done {
+		if res.err != nil {
+			if evalErr == nil {
+				evalErr = res.err
+			}
+			continue
+		}
+		if evalErr != nil {
+			continue
+		}
+		queries[res.index] = res.variation
+		completed++
+
+		if err := report(lensResponse{
+			Type:     "executing",
+			Message:  fmt.Sprintf("Evaluating queries... (%d/%d)", completed, len(variations)),
+			Progress: (float64(completed) / float64(len(variations))) * 100,
+		}); err != nil {
+			evalErr = err
+		}
+	}
+	if evalErr != nil {
+		return nil, evalErr
+	}
+
+	if err := report(lensResponse{Type: "message", Message: "Evaluating predictions."}); err != nil {
+		return nil, err
+	}
+
+	for _, objective := range requestedObjectives {
+		ltrCandidate, ok := ltrCandidates[objective]
+		if !ok {
+			continue
+		}
+		ltrQuery, err := backend(ltrCandidate.Query)
+		if err != nil {
+			return nil, err
+		}
+		ev, err := evaluateQuery(qc, s, settings, qrels.Qrels["0"], ltrCandidate.Query)
+		if err != nil {
+			return nil, err
+		}
+		shape, ok := objectiveShapes[objective]
+		if !ok {
+			shape = "triangle"
+		}
+		queries = append(queries, queryVariation{
+			Query:              ltrQuery,
+			Shape:              shape,
+			Objective:          objective,
+			Transformation:     transformationType[ltrCandidate.TransformationID],
+			NumTransformations: len(variations),
+			Precision:          ev.Precision,
+			Recall:             ev.Recall,
+			F1:                 ev.F1,
+			NumRet:             ev.NumRet,
+		})
+	}
+
+	origEv, err := evaluateQuery(qc, s, settings, qrels.Qrels["0"], candidate.Query)
+	if err != nil {
+		return nil, err
+	}
+	queries = append(queries, queryVariation{
+		Query:              rawQuery,
+		Shape:              "cross",
+		Transformation:     "Original",
+		NumTransformations: len(variations),
+		Precision:          origEv.Precision,
+		Recall:             origEv.Recall,
+		F1:                 origEv.F1,
+		NumRet:             origEv.NumRet,
+	})
+
+	return queries, nil
+}
+
+// defaultConnectionSize is the page size used when a lensRequest doesn't
+// specify First.
+const defaultConnectionSize = 20
+
+// variationCursor is a stable cursor for the edge at index within its
+// filtered/sorted connection, derived from a hash of that index together
+// with v's compiled query text (the CQR that backend produced in
+// runVariations), transformation and objective. index makes the cursor
+// unique per edge by construction: two variations can otherwise compile to
+// the same query text under the same transformation (plain "circle"
+// variations with no objective) or the per-objective LTR loop can select
+// the same candidate for two different objectives, and either case would
+// collide without it. Re-running the same request reproduces the same
+// filtered/sorted order, so a page boundary still survives a re-run against
+// unchanged input.
+func variationCursor(index int, v queryVariation) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%d\x00%s\x00%s\x00%s", index, v.Transformation, v.Objective, v.Query)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// filterVariations keeps only the variations matching request's optional
+// MinRecall/Transformation/Shape fields.
+func filterVariations(queries []queryVariation, request lensRequest) []queryVariation {
+	filtered := make([]queryVariation, 0, len(queries))
+	for _, v := range queries {
+		if request.MinRecall > 0 && v.Recall < request.MinRecall {
+			continue
+		}
+		if request.Transformation != "" && v.Transformation != request.Transformation {
+			continue
+		}
+		if request.Shape != "" && v.Shape != request.Shape {
+			continue
+		}
+		filtered = append(filtered, v)
+	}
+	return filtered
+}
+
+// sortVariations orders queries in place by one of "f1" (the default),
+// "recall", "precision" or "numRet", highest first.
+func sortVariations(queries []queryVariation, sortBy string) {
+	key := func(v queryVariation) float64 {
+		switch sortBy {
+		case "recall":
+			return v.Recall
+		case "precision":
+			return v.Precision
+		case "numRet":
+			return v.NumRet
+		default:
+			return v.F1
+		}
+	}
+	sort.SliceStable(queries, func(i, j int) bool {
+		return key(queries[i]) > key(queries[j])
+	})
+}
+
+// paginateVariations filters and sorts queries per request, then walks the
+// cursor named by request.After to build the next page of the connection.
+// It is generic over its caller: both the websocket handler and the JSON
+// REST endpoint page the same runVariations output through it.
+func paginateVariations(queries []queryVariation, request lensRequest) queryConnection {
+	filtered := filterVariations(queries, request)
+	sortVariations(filtered, request.SortBy)
+
+	start := 0
+	if request.After != "" {
+		for i, v := range filtered {
+			if variationCursor(i, v) == request.After {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	size := request.First
+	if size <= 0 {
+		size = defaultConnectionSize
+	}
+	end := start
+	if start < len(filtered) {
+		end += size
+		if end > len(filtered) {
+			end = len(filtered)
+		}
+	}
+
+	page := filtered[start:end]
+	edges := make([]queryEdge, len(page))
+	for i, v := range page {
+		edges[i] = queryEdge{Cursor: variationCursor(start+i, v), Node: v}
+	}
+
+	info := pageInfo{HasNextPage: end < len(filtered)}
+	if len(edges) > 0 {
+		info.EndCursor = edges[len(edges)-1].Cursor
+	}
+
+	return queryConnection{Edges: edges, PageInfo: info}
+}
+
+const (
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = (pongWait * 9) / 10
+)
+
+// connDeadline is a resettable, cancellable deadline, modeled on the
+// pipeDeadline helper backing net.Pipe() in the standard library: a timer
+// closes a channel when the deadline elapses, and callers select on that
+// channel to be woken without blocking on the I/O call itself. A zero
+// time.Time means "no deadline". Resetting the deadline swaps in a fresh
+// channel so a goroutine already selecting on an expired one never observes
+// state from a later deadline.
+type connDeadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newConnDeadline() *connDeadline {
+	return &connDeadline{cancel: make(chan struct{})}
+}
+
+func (d *connDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	switch {
+	case t.IsZero():
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+	case time.Until(t) > 0:
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(time.Until(t), func() { close(cancel) })
+	default:
+		if !closed {
+			close(d.cancel)
+		}
+	}
+}
+
+func (d *connDeadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func wsEvent(ws *websocket.Conn, s searchrefiner.Server, settings searchrefiner.Settings) {
+	defer func() {
+		_ = ws.Close()
+	}()
+
+	var writeMu sync.Mutex
+	readDeadline := newConnDeadline()
+	writeDeadline := newConnDeadline()
+
+	// send serializes every outgoing message onto the one connection gorilla
+	// allows a concurrent writer on, and enforces a write deadline around it.
+	send := func(resp lensResponse) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		deadline := time.Now().Add(writeWait)
+		writeDeadline.set(deadline)
+		defer writeDeadline.set(time.Time{})
+		if err := ws.SetWriteDeadline(deadline); err != nil {
+			return err
+		}
+		return ws.WriteJSON(resp)
+	}
+
+	resetReadDeadline := func() error {
+		deadline := time.Now().Add(pongWait)
+		readDeadline.set(deadline)
+		return ws.SetReadDeadline(deadline)
+	}
 
-				err = ws.WriteJSON(lensResponse{
-					Type:     "executing",
-					Message:  fmt.Sprintf("Evaluating queries... (%d/%d)", i, len(variations)),
-					Progress: (float64(i) / float64(len(variations))) * 100,
-				})
+	ws.SetPongHandler(func(string) error {
+		return resetReadDeadline()
+	})
+
+	connCtx, connCancel := context.WithCancel(context.Background())
+	defer connCancel()
+
+	pinger := time.NewTicker(pingPeriod)
+	defer pinger.Stop()
+	go func() {
+		for {
+			select {
+			case <-pinger.C:
+				writeMu.Lock()
+				err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+				writeMu.Unlock()
 				if err != nil {
-					log.Println("write:", err)
+					connCancel()
 					return
 				}
+			case <-connCtx.Done():
+				return
 			}
+		}
+	}()
 
-			err = ws.WriteJSON(lensResponse{
-				Type:    "message",
-				Message: "Evaluating predictions.",
-			})
-			if err != nil {
-				log.Println("write:", err)
+	// reads is fed by a single blocking reader goroutine so the event loop
+	// below never busy-polls; it is selected against the read deadline and
+	// the connection's cancellation so a stalled or disconnected client is
+	// noticed promptly.
+	type readResult struct {
+		request lensRequest
+		err     error
+	}
+	reads := make(chan readResult)
+	go func() {
+		defer close(reads)
+		sendRead := func(res readResult) bool {
+			select {
+			case reads <- res:
+				return true
+			case <-connCtx.Done():
+				return false
+			}
+		}
+		if err := resetReadDeadline(); err != nil {
+			sendRead(readResult{err: err})
+			return
+		}
+		for {
+			var request lensRequest
+			err := ws.ReadJSON(&request)
+			if !sendRead(readResult{request: request, err: err}) {
 				return
 			}
-
-			ltrQuery, err := backend(candidate.Query)
 			if err != nil {
-				log.Println("write:", err)
 				return
 			}
-			ltrPq := pipeline.NewQuery("0", "0", ltrCandidate.Query)
-			t1, err := combinator.NewShallowLogicalTree(ltrPq, s.Entrez, settings.Relevant)
-			if err != nil {
-				log.Println("write:", err)
+			if err := resetReadDeadline(); err != nil {
 				return
 			}
-			ltrResuts := t1.Documents(qc).Results(ltrPq, "0")
-			queries = append(queries, queryVariation{
-				Query:              ltrQuery,
-				Shape:              "triangle",
-				Transformation:     transformationType[ltrCandidate.TransformationID],
-				NumTransformations: len(variations),
-				Precision:          eval.Precision.Score(&ltrResuts, qrels.Qrels["0"]),
-				Recall:             eval.Recall.Score(&ltrResuts, qrels.Qrels["0"]),
-				F1:                 eval.F1Measure.Score(&ltrResuts, qrels.Qrels["0"]),
-				NumRet:             float64(len(ltrResuts)),
-			})
-
-			pq := pipeline.NewQuery("0", "0", candidate.Query)
-			t2, err := combinator.NewShallowLogicalTree(pq, s.Entrez, settings.Relevant)
-			if err != nil {
-				log.Println("write:", err)
+		}
+	}()
+
+	var cancelPrevious context.CancelFunc
+	defer func() {
+		if cancelPrevious != nil {
+			cancelPrevious()
+		}
+	}()
+
+	for {
+		select {
+		case res, ok := <-reads:
+			if !ok {
 				return
 			}
-			origResuts := t2.Documents(qc).Results(ltrPq, "0")
-			queries = append(queries, queryVariation{
-				Query:              rawQuery,
-				Shape:              "cross",
-				Transformation:     "Original",
-				NumTransformations: len(variations),
-				Precision:          eval.Precision.Score(&origResuts, qrels.Qrels["0"]),
-				Recall:             eval.Recall.Score(&origResuts, qrels.Qrels["0"]),
-				F1:                 eval.F1Measure.Score(&origResuts, qrels.Qrels["0"]),
-				NumRet:             float64(len(origResuts)),
-			})
-
-			sort.Slice(queries, func(i, j int) bool {
-				return queries[i].F1 < queries[j].F1
-			})
-
-			err = ws.WriteJSON(lensResponse{
-				Type:    "queries",
-				Queries: queries,
-			})
-			if err != nil {
-				log.Println("write:", err)
+			if res.err != nil {
+				log.Println("read:", res.err)
 				return
 			}
+
+			// A new query preempts any evaluation still running for the
+			// previous one.
+			if cancelPrevious != nil {
+				cancelPrevious()
+			}
+			reqCtx, cancel := context.WithCancel(connCtx)
+			cancelPrevious = cancel
+
+			go func(ctx context.Context, request lensRequest) {
+				queries, err := runVariations(ctx, request, s, settings, send)
+				if err != nil {
+					if err != context.Canceled {
+						log.Println("write:", err)
+					}
+					return
+				}
+				connection := paginateVariations(queries, request)
+				if err := send(lensResponse{Type: "queries", Queries: &connection}); err != nil {
+					log.Println("write:", err)
+				}
+			}(reqCtx, res.request)
+		case <-readDeadline.wait():
+			log.Println("read: i/o timeout")
+			return
+		case <-connCtx.Done():
+			return
 		}
 	}
 }
@@ -310,11 +891,42 @@ func handleVariations(s searchrefiner.Server, c *gin.Context) {
 	go wsEvent(ws, s, searchrefiner.GetSettings(s, c))
 }
 
+// handleVariationsJSON implements the `?lens=json` REST endpoint: it runs
+// the same pipeline as the websocket handler but returns the final
+// lensResponse as a single JSON document, with no progress streaming.
+func handleVariationsJSON(s searchrefiner.Server, c *gin.Context) {
+	var request lensRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	queries, err := runVariations(c.Request.Context(), request, s, searchrefiner.GetSettings(s, c), nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	connection := paginateVariations(queries, request)
+	c.JSON(http.StatusOK, lensResponse{
+		Type:    "queries",
+		Queries: &connection,
+	})
+}
+
 func (QueryLensPlugin) Serve(s searchrefiner.Server, c *gin.Context) {
 	if c.Query("lens") == "y" {
 		handleVariations(s, c)
 		return
 	}
+	if c.Query("lens") == "json" {
+		handleVariationsJSON(s, c)
+		return
+	}
+	if c.Query("lens") == "objectives" {
+		c.JSON(http.StatusOK, gin.H{"objectives": objectives()})
+		return
+	}
 	rawQuery := c.PostForm("query")
 	lang := c.PostForm("lang")
 	c.Render(http.StatusOK, searchrefiner.RenderPlugin(searchrefiner.TemplatePlugin("plugin/querylens/index.html"), searchrefiner.Query{
@@ -0,0 +1,98 @@
+package main
+
+import "testing"
+
+// TestVariationCursorUniqueness guards against two ways distinct edges can
+// otherwise carry identical content: the per-objective LTR loop selecting
+// the same candidate query for two different objectives, and two plain
+// "circle" variations (no objective set) compiling to the same query text
+// under the same transformation. In both cases the index within the
+// connection must keep the cursors apart.
+func TestVariationCursorUniqueness(t *testing.T) {
+	a := queryVariation{
+		Query:          "identical query text",
+		Transformation: "MeSH Explosion",
+		Objective:      "precision",
+	}
+	b := a
+	b.Objective = "recall"
+
+	cursorA := variationCursor(0, a)
+	cursorB := variationCursor(1, b)
+	if cursorA == cursorB {
+		t.Fatalf("variations differing only by Objective got the same cursor: %s", cursorA)
+	}
+
+	if got := variationCursor(0, a); got != cursorA {
+		t.Fatalf("variationCursor is not stable across calls: got %s, want %s", got, cursorA)
+	}
+
+	plainA := queryVariation{Query: "same compiled text", Transformation: "Clause Removal"}
+	plainB := plainA
+	if got := variationCursor(0, plainA); got == variationCursor(1, plainB) {
+		t.Fatalf("two identical plain variations at different indexes got the same cursor: %s", got)
+	}
+}
+
+// TestPaginateVariationsResumesAtCorrectOffset checks that paging past a
+// cursor shared by no other edge (after the chunk0-5 review fix, cursors
+// are unique per edge) serves the next edge exactly once.
+func TestPaginateVariationsResumesAtCorrectOffset(t *testing.T) {
+	queries := []queryVariation{
+		{Query: "q", Transformation: "t", Objective: "precision", F1: 0.9},
+		{Query: "q", Transformation: "t", Objective: "recall", F1: 0.9},
+		{Query: "other", Transformation: "t", F1: 0.5},
+	}
+
+	first := paginateVariations(queries, lensRequest{First: 1})
+	if len(first.Edges) != 1 {
+		t.Fatalf("expected 1 edge, got %d", len(first.Edges))
+	}
+	if !first.PageInfo.HasNextPage {
+		t.Fatalf("expected HasNextPage to be true")
+	}
+
+	second := paginateVariations(queries, lensRequest{First: 1, After: first.PageInfo.EndCursor})
+	if len(second.Edges) != 1 {
+		t.Fatalf("expected 1 edge, got %d", len(second.Edges))
+	}
+	if second.Edges[0].Cursor == first.Edges[0].Cursor {
+		t.Fatalf("second page repeated the first edge's cursor %s", first.Edges[0].Cursor)
+	}
+
+	third := paginateVariations(queries, lensRequest{First: 1, After: second.PageInfo.EndCursor})
+	if len(third.Edges) != 1 {
+		t.Fatalf("expected 1 edge, got %d", len(third.Edges))
+	}
+	if third.PageInfo.HasNextPage {
+		t.Fatalf("expected HasNextPage to be false on the last page")
+	}
+}
+
+// TestPaginateVariationsHandlesDuplicatePlainVariations covers two plain
+// "circle" variations (no objective, generateVariations rather than the LTR
+// loop) that compile to identical query text under the same transformation.
+// Before folding the edge's index into its cursor, the walk in
+// paginateVariations would resume after the first match and silently
+// re-serve the second duplicate.
+func TestPaginateVariationsHandlesDuplicatePlainVariations(t *testing.T) {
+	queries := []queryVariation{
+		{Query: "dup", Transformation: "Clause Removal", F1: 0.9},
+		{Query: "dup", Transformation: "Clause Removal", F1: 0.9},
+		{Query: "last", Transformation: "Clause Removal", F1: 0.1},
+	}
+
+	first := paginateVariations(queries, lensRequest{First: 1})
+	second := paginateVariations(queries, lensRequest{First: 1, After: first.PageInfo.EndCursor})
+	if second.Edges[0].Cursor == first.Edges[0].Cursor {
+		t.Fatalf("second page repeated the first edge's cursor for a duplicate variation: %s", first.Edges[0].Cursor)
+	}
+
+	third := paginateVariations(queries, lensRequest{First: 1, After: second.PageInfo.EndCursor})
+	if len(third.Edges) != 1 || third.Edges[0].Node.Query != "last" {
+		t.Fatalf("expected the third page to serve the remaining distinct variation, got %+v", third.Edges)
+	}
+	if third.PageInfo.HasNextPage {
+		t.Fatalf("expected HasNextPage to be false on the last page")
+	}
+}